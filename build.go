@@ -2,18 +2,11 @@ package build
 
 import (
 	"context"
-	"crypto/sha1"
-	"encoding/hex"
 	"flag"
-	"io"
 	"log"
 	"os"
 	"os/exec"
-	"path"
-	"path/filepath"
 	"strings"
-	"sync"
-	"time"
 )
 
 // CompileConfig wraps common compiler configuration options
@@ -27,8 +20,32 @@ type CompileConfig struct {
 	// Version contains the version number for the software package, if one can be determined
 	Version string
 
-	GOOS   string
-	GOARCH string
+	// VersionInfo carries the fuller set of version metadata Version is
+	// derived from. It is promoted so callers can write conf.LDFlags(pkg)
+	// directly.
+	VersionInfo
+
+	GOOS       string
+	GOARCH     string
+	GOARM      string
+	CGOEnabled bool
+
+	// Name is the executable name passed to Main. Matrix builds use it to
+	// resolve a Target's output-name template.
+	Name string
+
+	// Output is the resolved output name for this build. It is only set by
+	// a matrix build; single-target builds leave it empty and decide their
+	// own output path.
+	Output string
+
+	// GOROOT and ToolchainVersion are set by a Toolchains run to the
+	// downloaded toolchain's install root and Go version. Env carries the
+	// same information (plus a PATH with the toolchain's bin directory
+	// prepended) ready to append to an *exec.Cmd.Env.
+	GOROOT           string
+	ToolchainVersion string
+	Env              []string
 }
 
 type job func(ctx context.Context) error
@@ -45,17 +62,79 @@ type WatchList struct {
 	FileFilter []string
 }
 
+// options bundles the optional, rarely-set Main behavior contributed by
+// Option values, e.g. a cross-compilation Matrix.
+type options struct {
+	matrix     Matrix
+	release    *Package
+	hooks      Hooks
+	toolchains Toolchains
+}
+
+// Option configures optional Main behavior beyond the required executable
+// name, compile step, and watch list.
+type Option func(*options)
+
+// WithMatrix makes Main fan out compile across every Target in m instead of
+// running a single build, honoring the -parallel and -fail-fast flags. When
+// WithToolchains is also given, the toolchain run takes precedence and the
+// matrix is not built.
+func WithMatrix(m Matrix) Option {
+	return func(o *options) { o.matrix = m }
+}
+
+// WithRelease enables the -release DIR flag, which packages every
+// successfully-built matrix target into a distributable archive in DIR
+// using pkg's resource manifest. It has no effect without WithMatrix.
+func WithRelease(pkg Package) Option {
+	return func(o *options) { o.release = &pkg }
+}
+
+// WithHooks runs hooks.PreBuild and hooks.PostBuild around every compile,
+// and hooks.OnReload after each successful watch-mode rebuild.
+func WithHooks(hooks Hooks) Option {
+	return func(o *options) { o.hooks = hooks }
+}
+
+// WithToolchains enables the -toolchains flag, which runs compile once per
+// downloaded Go version in tc instead of once against whatever `go` is on
+// the system PATH.
+func WithToolchains(tc Toolchains) Option {
+	return func(o *options) { o.toolchains = tc }
+}
+
 // Main runs the build script. Calling Main will also call flag.Parse().
-func Main(executableName string, compile CompilerJob, watchList WatchList) {
+func Main(executableName string, compile CompilerJob, watchList WatchList, opts ...Option) {
 	var conf CompileConfig
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	watch := false
 	run := false
+	parallel := 1
+	failFast := false
+	releaseDir := ""
+	versionOverride := ""
+	toolchainsFlag := ""
 	flag.BoolVar(&conf.Development, "development", false, "Create a development build")
 	flag.BoolVar(&conf.Quick, "quick", false, "Create a development build")
 	flag.StringVar(&conf.GOARCH, "GOARCH", "", "Cross-compile for architecture")
 	flag.StringVar(&conf.GOOS, "GOOS", "", "Cross-compile for operating system")
 	flag.BoolVar(&watch, "watch", false, "Watch source tree for changes")
 	flag.BoolVar(&run, "run", false, "Run "+executableName+" upon successful compilation")
+	flag.StringVar(&versionOverride, "version-override", "", "Force this version string instead of deriving it from git describe")
+	if len(o.matrix.Targets) > 0 {
+		flag.IntVar(&parallel, "parallel", 1, "Number of matrix targets to build concurrently")
+		flag.BoolVar(&failFast, "fail-fast", false, "Abort remaining matrix targets as soon as one fails")
+		if o.release != nil {
+			flag.StringVar(&releaseDir, "release", "", "Package successfully-built targets into archives in this directory")
+		}
+	}
+	if len(o.toolchains.Versions) > 0 {
+		flag.StringVar(&toolchainsFlag, "toolchains", "", "Comma-separated subset of toolchain versions to run (default: all)")
+	}
 	flag.Parse()
 
 	if conf.Development && conf.Quick {
@@ -66,11 +145,25 @@ func Main(executableName string, compile CompilerJob, watchList WatchList) {
 		//log.Printf("")
 	}
 
-	compile = withVersion(compile)
+	conf.Name = executableName
+	compile = withVersion(withHooks(compile, o.hooks), versionOverride)
 
 	var theJob job
 
-	if run {
+	switch {
+	case len(o.toolchains.Versions) > 0:
+		var selected []string
+		if toolchainsFlag != "" {
+			selected = strings.Split(toolchainsFlag, ",")
+		}
+		theJob = func(ctx context.Context) error {
+			return runToolchains(ctx, compile, conf, o.toolchains, selected)
+		}
+	case len(o.matrix.Targets) > 0:
+		theJob = func(ctx context.Context) error {
+			return runMatrix(ctx, compile, conf, o.matrix, parallel, failFast, releaseDir, o.release, versionOverride)
+		}
+	case run:
 		theJob = func(ctx context.Context) error {
 			err := compile(ctx, conf)
 			if err != nil {
@@ -79,14 +172,17 @@ func Main(executableName string, compile CompilerJob, watchList WatchList) {
 			runArgs := append([]string{executableName}, flag.Args()...)
 			return Passthru(ctx, runArgs...)
 		}
-	} else {
+	default:
 		theJob = func(ctx context.Context) error {
 			return compile(ctx, conf)
 		}
 	}
 
 	if watch {
-		theJob = watchSourceTree(watchList, theJob)
+		onReload := func(ctx context.Context) error {
+			return runHooks(ctx, conf, o.hooks.OnReload)
+		}
+		theJob = watchSourceTree(watchList, theJob, onReload)
 	}
 
 	err := theJob(context.Background())
@@ -95,19 +191,6 @@ func Main(executableName string, compile CompilerJob, watchList WatchList) {
 	}
 }
 
-func withVersion(compile CompilerJob) CompilerJob {
-	return func(ctx context.Context, conf CompileConfig) error {
-		// Determine version
-		conf.Version = "unknown-version"
-		gitDescCmd := exec.CommandContext(ctx, "git", "describe")
-		gitDescribe, err := gitDescCmd.Output()
-		if err == nil && len(gitDescribe) > 0 {
-			conf.Version = strings.TrimLeft(strings.TrimSpace(string(gitDescribe)), "v")
-		}
-		return compile(ctx, conf)
-	}
-}
-
 // Passthru executes the command and arguments in argv, and returns an error if
 // the exit status wasn't 0. Stdin, stdout, and stderr are redirected to the
 // parent process' stdin/stdout/stderr.
@@ -125,90 +208,3 @@ func PassthruCmd(c *exec.Cmd) error {
 	c.Stdin = os.Stdin
 	return c.Run()
 }
-
-func watchSourceTree(watchList WatchList, childJob job) job {
-	return func(ctx context.Context) error {
-		var mu sync.Mutex
-		for {
-			lastHash := sourceTreeHash(watchList)
-			current := lastHash
-			cctx, cancel := context.WithCancel(ctx)
-			go func() {
-				mu.Lock()
-				err := childJob(cctx)
-				if err != nil {
-					log.Printf("child process: %s", err)
-				}
-				mu.Unlock()
-			}()
-
-			for lastHash == current {
-				time.Sleep(250 * time.Millisecond)
-				current = sourceTreeHash(watchList)
-			}
-
-			log.Printf("Source change detected - rebuilding")
-			cancel()
-		}
-	}
-}
-
-func sourceTreeHash(w WatchList) string {
-	h := sha1.New()
-	for _, d := range w.Paths {
-		h.Write(directoryHash(0, d, w))
-	}
-	return hex.EncodeToString(h.Sum(nil))
-}
-
-func directoryHash(level int, filePath string, w WatchList) []byte {
-	h := sha1.New()
-	h.Write([]byte(filePath))
-
-	fi, err := os.Stat(filePath)
-	if err != nil {
-		return h.Sum(nil)
-	}
-	if fi.IsDir() {
-		base := filepath.Base(filePath)
-		if level > 0 {
-			if base == ".git" || base == ".." || base == "node_modules" || base == "build" || base == "doc" {
-				return []byte{}
-			}
-		}
-		// recurse
-		var names []string
-		f, err := os.Open(filePath)
-		if err == nil {
-			names, err = f.Readdirnames(-1)
-		}
-		if err == nil {
-			for _, name := range names {
-				if name == "" || name[0] == '.' {
-					continue
-				}
-				h.Write(directoryHash(level+1, path.Join(filePath, name), w))
-			}
-		}
-	} else {
-		if w.FileFilter != nil {
-			found := false
-			for _, pattern := range w.FileFilter {
-				if ok, _ := filepath.Match(pattern, filePath); ok {
-					found = true
-				} else if ok, _ := filepath.Match(pattern, filepath.Base(filePath)); ok {
-					found = true
-				}
-			}
-			if !found {
-				return []byte{}
-			}
-		}
-		f, err := os.Open(filePath)
-		if err == nil {
-			io.Copy(h, f)
-			f.Close()
-		}
-	}
-	return h.Sum(nil)
-}
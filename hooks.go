@@ -0,0 +1,67 @@
+package build
+
+import (
+	"context"
+	"fmt"
+)
+
+// Hooks are extra CompilerJobs run around the build, for codegen, asset
+// pipelines, packaging, or notifying other processes. A hook that's just a
+// command line, with no need for conf, can be built with CmdJob instead of
+// writing a closure by hand. See WithHooks.
+type Hooks struct {
+	// PreBuild runs, in order, before every compile. A PreBuild failure
+	// short-circuits the compile.
+	PreBuild []CompilerJob
+
+	// PostBuild runs, in order, only after compile succeeds.
+	PostBuild []CompilerJob
+
+	// OnReload runs, in order, after a watch-mode rebuild triggered by a
+	// source change completes successfully. It does not run after the
+	// initial build.
+	OnReload []CompilerJob
+}
+
+// CmdJob adapts a plain command line into a CompilerJob by running it
+// through Passthru, so a Hooks entry can be written as
+// CmdJob("protoc", "--go_out=.", "api.proto") instead of a hand-written
+// closure.
+func CmdJob(argv ...string) CompilerJob {
+	return func(ctx context.Context, _ CompileConfig) error {
+		return Passthru(ctx, argv...)
+	}
+}
+
+// runHooks runs each hook in order against ctx and conf, stopping at and
+// returning the first error.
+func runHooks(ctx context.Context, conf CompileConfig, hooks []CompilerJob) error {
+	for _, h := range hooks {
+		if err := h(ctx, conf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withHooks wraps compile so hooks.PreBuild runs beforehand, and
+// hooks.PostBuild runs afterward only if compile succeeded. Both share
+// compile's context, so cancellation on a new source change propagates into
+// the hooks too.
+func withHooks(compile CompilerJob, hooks Hooks) CompilerJob {
+	if len(hooks.PreBuild) == 0 && len(hooks.PostBuild) == 0 {
+		return compile
+	}
+	return func(ctx context.Context, conf CompileConfig) error {
+		if err := runHooks(ctx, conf, hooks.PreBuild); err != nil {
+			return fmt.Errorf("pre-build hook: %w", err)
+		}
+		if err := compile(ctx, conf); err != nil {
+			return err
+		}
+		if err := runHooks(ctx, conf, hooks.PostBuild); err != nil {
+			return fmt.Errorf("post-build hook: %w", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,173 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Target describes a single GOOS/GOARCH combination to build as part of a
+// Matrix, plus the flags that would normally accompany it on the go build
+// command line.
+type Target struct {
+	GOOS       string
+	GOARCH     string
+	GOARM      string
+	CGOEnabled bool
+
+	// Output overrides the default output-name template,
+	// "{{.Name}}-{{.Version}}-{{.GOOS}}-{{.GOARCH}}{{.Ext}}", for this
+	// target.
+	Output string
+}
+
+// Matrix is a set of Targets to build in a single Main invocation. See
+// WithMatrix.
+type Matrix struct {
+	Targets []Target
+}
+
+const defaultOutputTemplate = "{{.Name}}-{{.Version}}-{{.GOOS}}-{{.GOARCH}}{{.Ext}}"
+
+// outputName resolves this Target's output-name template against conf.
+func (t Target) outputName(conf CompileConfig) (string, error) {
+	tmplText := t.Output
+	if tmplText == "" {
+		tmplText = defaultOutputTemplate
+	}
+
+	ext := ""
+	if conf.GOOS == "windows" {
+		ext = ".exe"
+	}
+
+	tmpl, err := template.New("output").Parse(tmplText)
+	if err != nil {
+		return "", fmt.Errorf("output name template: %w", err)
+	}
+	var buf strings.Builder
+	err = tmpl.Execute(&buf, struct{ Name, Version, GOOS, GOARCH, Ext string }{
+		Name:    conf.Name,
+		Version: conf.Version,
+		GOOS:    conf.GOOS,
+		GOARCH:  conf.GOARCH,
+		Ext:     ext,
+	})
+	if err != nil {
+		return "", fmt.Errorf("output name template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// targetResult records how a single matrix target's compile went, for the
+// end-of-run summary.
+type targetResult struct {
+	Target   Target
+	Output   string
+	Duration time.Duration
+	Err      error
+}
+
+// runMatrix runs compile once per Target in m, up to parallel at a time. A
+// target failure never cancels its siblings unless failFast is set, in
+// which case the remaining targets are aborted via ctx cancellation. If
+// releaseDir and pkg are both set, every target that compiled successfully
+// is packaged into releaseDir once the matrix finishes.
+func runMatrix(ctx context.Context, compile CompilerJob, conf CompileConfig, m Matrix, parallel int, failFast bool, releaseDir string, pkg *Package, versionOverride string) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	// Resolve the version once up front so outputName and the release
+	// packaging below see it; compile's own withVersion wrapping finds it
+	// already populated and leaves it as is, so every target gets this same
+	// version.
+	conf.VersionInfo = gitVersionInfo(ctx)
+	if versionOverride != "" {
+		conf.VersionInfo.Version = versionOverride
+	}
+	conf.Version = conf.VersionInfo.Version
+
+	g, gctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, parallel)
+	results := make([]targetResult, len(m.Targets))
+
+	var mu sync.Mutex
+	var firstErr error
+
+	for i, t := range m.Targets {
+		i, t := i, t
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			tconf := conf
+			tconf.GOOS = t.GOOS
+			tconf.GOARCH = t.GOARCH
+			tconf.GOARM = t.GOARM
+			tconf.CGOEnabled = t.CGOEnabled
+
+			output, err := t.outputName(tconf)
+			if err == nil {
+				tconf.Output = output
+				start := time.Now()
+				err = compile(gctx, tconf)
+				results[i] = targetResult{Target: t, Output: output, Duration: time.Since(start), Err: err}
+			} else {
+				results[i] = targetResult{Target: t, Err: err}
+			}
+
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				if failFast {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	// Only propagates when failFast actually cancelled gctx; otherwise every
+	// Go func above returns nil and failures are reported via firstErr.
+	_ = g.Wait()
+
+	logMatrixSummary(results)
+
+	if releaseDir != "" && pkg != nil {
+		bins := make([]Binary, 0, len(results))
+		for _, r := range results {
+			if r.Err == nil {
+				bins = append(bins, Binary{Target: r.Target, Path: r.Output})
+			}
+		}
+		p := *pkg
+		p.Name = conf.Name
+		p.Version = conf.Version
+		if _, err := p.ArchiveAll(releaseDir, bins); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func logMatrixSummary(results []targetResult) {
+	log.Printf("Matrix build summary:")
+	for _, r := range results {
+		status := "ok"
+		if r.Err != nil {
+			status = fmt.Sprintf("FAILED: %s", r.Err)
+		}
+		log.Printf("  %s/%s %s (%s) - %s", r.Target.GOOS, r.Target.GOARCH, r.Output, r.Duration.Round(time.Millisecond), status)
+	}
+}
@@ -0,0 +1,306 @@
+package build
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Resource is a file or directory copied verbatim into every release
+// archive, e.g. README.md, LICENSE, or an assets directory.
+type Resource struct {
+	// Path is the source path on disk, relative to the working directory.
+	Path string
+
+	// Dest is the path the resource is stored under inside the archive. It
+	// defaults to filepath.Base(Path).
+	Dest string
+}
+
+// Binary is one compiled artifact to package, tying a Target to the binary
+// a matrix build produced for it.
+type Binary struct {
+	Target Target
+	Path   string
+}
+
+// Package describes a release archive build step: the binaries produced by
+// a Matrix build, plus a manifest of resources bundled into every archive.
+// See WithRelease.
+type Package struct {
+	Name    string
+	Version string
+
+	// Resources are copied into every archive alongside the binary.
+	Resources []Resource
+
+	// ChecksumFile, if set, names a SHA256SUMS-style file written to the
+	// output directory covering every archive ArchiveAll produces.
+	ChecksumFile string
+}
+
+// archiveName returns the conventional "{name}-{version}-{goos}-{goarch}"
+// base name for bin's archive, mirroring the Go project's makerelease
+// layout.
+func (p Package) archiveName(bin Binary) string {
+	return fmt.Sprintf("%s-%s-%s-%s", p.Name, p.Version, bin.Target.GOOS, bin.Target.GOARCH)
+}
+
+// Archive packages bin plus every Resource in p into outDir and returns the
+// archive's path. GOOS windows and darwin get a .zip; every other GOOS gets
+// a .tar.gz, matching what's conventionally expected on each platform.
+func (p Package) Archive(outDir string, bin Binary) (string, error) {
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return "", err
+	}
+
+	base := p.archiveName(bin)
+	binName := filepath.Base(bin.Path)
+
+	if bin.Target.GOOS == "windows" || bin.Target.GOOS == "darwin" {
+		archivePath := filepath.Join(outDir, base+".zip")
+		return archivePath, p.writeZip(archivePath, bin, binName)
+	}
+	archivePath := filepath.Join(outDir, base+".tar.gz")
+	return archivePath, p.writeTarGz(archivePath, bin, binName)
+}
+
+// ArchiveAll packages every Binary in bins, then, if p.ChecksumFile is set,
+// writes a checksum file covering every archive produced.
+func (p Package) ArchiveAll(outDir string, bins []Binary) ([]string, error) {
+	archives := make([]string, 0, len(bins))
+	for _, bin := range bins {
+		archivePath, err := p.Archive(outDir, bin)
+		if err != nil {
+			return archives, fmt.Errorf("package %s/%s: %w", bin.Target.GOOS, bin.Target.GOARCH, err)
+		}
+		archives = append(archives, archivePath)
+	}
+
+	if p.ChecksumFile != "" {
+		if err := writeChecksums(filepath.Join(outDir, p.ChecksumFile), archives); err != nil {
+			return archives, err
+		}
+	}
+	return archives, nil
+}
+
+// writeTarGz streams bin and every Resource into a tar.gz at archivePath.
+// The tar and gzip footers are only flushed on Close, so a Close error
+// (e.g. disk full) is just as much a packaging failure as a write error and
+// must not be swallowed — it's the difference between a valid archive and
+// a silently truncated one.
+func (p Package) writeTarGz(archivePath string, bin Binary, binName string) (err error) {
+	f, ferr := os.Create(archivePath)
+	if ferr != nil {
+		return ferr
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	gz := gzip.NewWriter(f)
+	defer func() {
+		if cerr := gz.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	tw := tar.NewWriter(gz)
+	defer func() {
+		if cerr := tw.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	if err = addFileToTar(tw, bin.Path, binName, true); err != nil {
+		return err
+	}
+	for _, r := range p.Resources {
+		if err = addResourceToTar(tw, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeZip streams bin and every Resource into a zip at archivePath. See
+// writeTarGz for why the deferred Close errors are captured rather than
+// discarded.
+func (p Package) writeZip(archivePath string, bin Binary, binName string) (err error) {
+	f, ferr := os.Create(archivePath)
+	if ferr != nil {
+		return ferr
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	zw := zip.NewWriter(f)
+	defer func() {
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	if err = addFileToZip(zw, bin.Path, binName, true); err != nil {
+		return err
+	}
+	for _, r := range p.Resources {
+		if err = addResourceToZip(zw, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addResourceToTar(tw *tar.Writer, r Resource) error {
+	dest := r.Dest
+	if dest == "" {
+		dest = filepath.Base(r.Path)
+	}
+	fi, err := os.Stat(r.Path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return addFileToTar(tw, r.Path, dest, false)
+	}
+	return filepath.Walk(r.Path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(r.Path, p)
+		if err != nil {
+			return err
+		}
+		return addFileToTar(tw, p, filepath.Join(dest, rel), false)
+	})
+}
+
+func addFileToTar(tw *tar.Writer, srcPath, archivePath string, executable bool) error {
+	fi, err := os.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	mode := fi.Mode()
+	if executable {
+		mode |= 0o111
+	}
+	hdr := &tar.Header{
+		Name: filepath.ToSlash(archivePath),
+		Mode: int64(mode.Perm()),
+		Size: fi.Size(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func addResourceToZip(zw *zip.Writer, r Resource) error {
+	dest := r.Dest
+	if dest == "" {
+		dest = filepath.Base(r.Path)
+	}
+	fi, err := os.Stat(r.Path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return addFileToZip(zw, r.Path, dest, false)
+	}
+	return filepath.Walk(r.Path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(r.Path, p)
+		if err != nil {
+			return err
+		}
+		return addFileToZip(zw, p, filepath.Join(dest, rel), false)
+	})
+}
+
+func addFileToZip(zw *zip.Writer, srcPath, archivePath string, executable bool) error {
+	fi, err := os.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	fh, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	fh.Name = filepath.ToSlash(archivePath)
+	fh.Method = zip.Deflate
+	mode := fi.Mode()
+	if executable {
+		mode |= 0o111
+	}
+	fh.SetMode(mode)
+
+	w, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// writeChecksums writes a SHA256SUMS-style file listing every archive's
+// checksum, one "sha256  basename" line per archive, sorted by filename.
+func writeChecksums(path string, archives []string) error {
+	sorted := append([]string(nil), archives...)
+	sort.Strings(sorted)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, a := range sorted {
+		sum, err := sha256File(a)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(f, "%s  %s\n", sum, filepath.Base(a))
+	}
+	return nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
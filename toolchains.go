@@ -0,0 +1,355 @@
+package build
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Toolchains runs a CompilerJob once per Go version in Versions, each
+// against a real `go` toolchain downloaded from go.dev/dl into a local
+// cache, instead of whatever `go` is on the system PATH. See
+// WithToolchains.
+type Toolchains struct {
+	// Versions are the Go versions to run against, e.g. "1.21.5", "1.22.3".
+	Versions []string
+
+	// Checksums pins the expected SHA256 of each version's tarball, keyed
+	// by version. A version missing from this map fails rather than
+	// installing an unverified download.
+	Checksums map[string]string
+
+	// CacheDir holds downloaded toolchains, one subdirectory per version.
+	// Defaults to "$XDG_CACHE_HOME/build.go/toolchains" (or
+	// "$HOME/.cache/build.go/toolchains" if XDG_CACHE_HOME is unset).
+	CacheDir string
+
+	// MaxAge purges a cached toolchain directory once it hasn't been used
+	// in this long. Defaults to 30 days.
+	MaxAge time.Duration
+}
+
+type toolchainResult struct {
+	Version string
+	Err     error
+}
+
+// runToolchains ensures every selected version (or, if selected is empty,
+// every tc.Versions) is downloaded, then runs compile once per version with
+// conf.GOROOT/conf.Env pointed at that install.
+func runToolchains(ctx context.Context, compile CompilerJob, conf CompileConfig, tc Toolchains, selected []string) error {
+	cacheDir := tc.CacheDir
+	if cacheDir == "" {
+		cacheDir = defaultToolchainCacheDir()
+	}
+	maxAge := tc.MaxAge
+	if maxAge <= 0 {
+		maxAge = 30 * 24 * time.Hour
+	}
+
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return err
+	}
+	purgeStaleToolchains(cacheDir, maxAge)
+
+	versions := tc.Versions
+	if len(selected) > 0 {
+		versions = selected
+	}
+
+	var results []toolchainResult
+	var firstErr error
+	for _, version := range versions {
+		goroot, err := ensureToolchain(ctx, cacheDir, version, tc.Checksums)
+		if err == nil {
+			vconf := conf
+			vconf.GOROOT = goroot
+			vconf.ToolchainVersion = version
+			vconf.Env = append(os.Environ(),
+				"GOROOT="+goroot,
+				"PATH="+filepath.Join(goroot, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"),
+			)
+			err = compile(ctx, vconf)
+		}
+		results = append(results, toolchainResult{Version: version, Err: err})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	log.Printf("Toolchain run summary:")
+	for _, r := range results {
+		status := "ok"
+		if r.Err != nil {
+			status = fmt.Sprintf("FAILED: %s", r.Err)
+		}
+		log.Printf("  go%s - %s", r.Version, status)
+	}
+
+	return firstErr
+}
+
+// ensureToolchain returns the GOROOT of version's install under cacheDir,
+// downloading and verifying it first if it isn't already cached.
+func ensureToolchain(ctx context.Context, cacheDir, version string, checksums map[string]string) (string, error) {
+	dir := filepath.Join(cacheDir, "go"+version)
+	marker := filepath.Join(dir, ".last-used")
+
+	if _, err := os.Stat(filepath.Join(dir, "bin", goBinaryName())); err == nil {
+		touchFile(marker)
+		return dir, nil
+	}
+
+	sum, ok := checksums[version]
+	if !ok {
+		return "", fmt.Errorf("no pinned checksum for go%s", version)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	if err := downloadToolchain(ctx, version, sum, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	touchFile(marker)
+	return dir, nil
+}
+
+// downloadToolchain fetches the official go.dev/dl release for the running
+// platform (a .tar.gz everywhere except Windows, which only ships .zip/.msi
+// archives), verifies it against sha256sum, and extracts it into destDir.
+func downloadToolchain(ctx context.Context, version, sha256sum, destDir string) error {
+	ext := "tar.gz"
+	if runtime.GOOS == "windows" {
+		ext = "zip"
+	}
+	url := fmt.Sprintf("https://go.dev/dl/go%s.%s-%s.%s", version, runtime.GOOS, runtime.GOARCH, ext)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s: %s", url, resp.Status)
+	}
+
+	tmp, err := os.CreateTemp("", "build-go-toolchain-*."+ext)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != sha256sum {
+		return fmt.Errorf("checksum mismatch for go%s: got %s, want %s", version, sum, sha256sum)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if ext == "zip" {
+		return extractToolchainZip(tmp, destDir)
+	}
+	return extractToolchain(tmp, destDir)
+}
+
+// safeJoin joins destDir and name, the way archive extraction always does,
+// but rejects any name (via ".." segments or an absolute path) whose
+// cleaned result would escape destDir — a zip-slip guard. The download's
+// SHA256 is checked against Checksums before extraction even starts, but
+// Checksums is caller-supplied, so a bad entry there plus a compromised
+// mirror shouldn't be able to write outside destDir.
+func safeJoin(destDir, name string) (string, error) {
+	target := filepath.Join(destDir, name)
+	rel, err := filepath.Rel(destDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("illegal archive entry %q escapes %s", name, destDir)
+	}
+	return target, nil
+}
+
+// extractToolchain unpacks a go.dev/dl tarball into destDir, stripping the
+// tarball's top-level "go/" directory so destDir itself becomes GOROOT.
+func extractToolchain(r io.Reader, destDir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(hdr.Name, "go/")
+		if name == "" || name == hdr.Name {
+			continue
+		}
+		target, err := safeJoin(destDir, name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// A link target that is absolute or climbs with ".." could
+			// point outside destDir and let a later entry write through it.
+			if filepath.IsAbs(hdr.Linkname) || strings.Contains("/"+filepath.ToSlash(hdr.Linkname)+"/", "/../") {
+				return fmt.Errorf("illegal symlink %q -> %q may escape %s", hdr.Name, hdr.Linkname, destDir)
+			}
+			os.Symlink(hdr.Linkname, target)
+		}
+	}
+}
+
+// extractToolchainZip unpacks a go.dev/dl Windows zip release into destDir,
+// stripping the archive's top-level "go/" directory so destDir itself
+// becomes GOROOT.
+func extractToolchainZip(f *os.File, destDir string) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	for _, zf := range zr.File {
+		name := strings.TrimPrefix(zf.Name, "go/")
+		if name == "" || name == zf.Name {
+			continue
+		}
+		target, err := safeJoin(destDir, name)
+		if err != nil {
+			return err
+		}
+
+		if zf.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, zf.Mode())
+		if err != nil {
+			rc.Close()
+			return err
+		}
+		_, err = io.Copy(out, rc)
+		out.Close()
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeStaleToolchains removes every cached toolchain directory under
+// cacheDir whose .last-used marker is older than maxAge.
+func purgeStaleToolchains(cacheDir string, maxAge time.Duration) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-maxAge)
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(cacheDir, e.Name())
+		fi, err := os.Stat(filepath.Join(dir, ".last-used"))
+		if err != nil {
+			fi, err = os.Stat(dir)
+			if err != nil {
+				continue
+			}
+		}
+		if fi.ModTime().Before(cutoff) {
+			log.Printf("toolchains: purging unused %s (last used %s)", dir, fi.ModTime().Format(time.RFC3339))
+			os.RemoveAll(dir)
+		}
+	}
+}
+
+func goBinaryName() string {
+	if runtime.GOOS == "windows" {
+		return "go.exe"
+	}
+	return "go"
+}
+
+func touchFile(path string) {
+	now := time.Now()
+	if os.Chtimes(path, now, now) == nil {
+		return
+	}
+	if f, err := os.Create(path); err == nil {
+		f.Close()
+	}
+}
+
+func defaultToolchainCacheDir() string {
+	base := os.Getenv("XDG_CACHE_HOME")
+	if base == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			base = filepath.Join(home, ".cache")
+		}
+	}
+	return filepath.Join(base, "build.go", "toolchains")
+}
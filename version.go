@@ -0,0 +1,90 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// VersionInfo captures the build-time metadata withVersion derives from
+// git, used to stamp binaries via LDFlags.
+type VersionInfo struct {
+	// Version is `git describe --tags --always --dirty`, with a leading "v"
+	// trimmed.
+	Version string
+
+	// Commit is the full `git rev-parse HEAD` hash.
+	Commit string
+
+	// BuildDate is the commit's `git log -1 --format=%cI` timestamp,
+	// falling back to the current time if that can't be determined (e.g.
+	// building from a tarball with no git history).
+	BuildDate string
+
+	// Dirty is "true" if the working tree had uncommitted changes at build
+	// time, "false" otherwise. Stored as a string so it can be spliced
+	// directly into a -X ldflag.
+	Dirty string
+}
+
+// LDFlags returns the "-X pkgPath.Field=value" arguments needed to stamp
+// v's Version, Commit, BuildDate, and Dirty onto package pkgPath's
+// like-named string variables via `go build -ldflags`.
+func (v VersionInfo) LDFlags(pkgPath string) []string {
+	return []string{
+		"-X", fmt.Sprintf("%s.Version=%s", pkgPath, v.Version),
+		"-X", fmt.Sprintf("%s.Commit=%s", pkgPath, v.Commit),
+		"-X", fmt.Sprintf("%s.BuildDate=%s", pkgPath, v.BuildDate),
+		"-X", fmt.Sprintf("%s.Dirty=%s", pkgPath, v.Dirty),
+	}
+}
+
+// withVersion wraps compile to populate conf.VersionInfo (and, for
+// compatibility, conf.Version) before calling through. If versionOverride
+// is non-empty, it replaces the derived Version outright, for CI pipelines
+// building from a detached ref or tarball where git describe can't help.
+//
+// If conf.VersionInfo is already populated (e.g. runMatrix resolved it once
+// up front for every target), it's left untouched instead of re-running
+// git describe/rev-parse/log per call, so every target sees exactly the
+// same version data rather than a second, possibly-diverging resolution.
+func withVersion(compile CompilerJob, versionOverride string) CompilerJob {
+	return func(ctx context.Context, conf CompileConfig) error {
+		if conf.VersionInfo.Version == "" {
+			conf.VersionInfo = gitVersionInfo(ctx)
+			if versionOverride != "" {
+				conf.VersionInfo.Version = versionOverride
+			}
+		}
+		conf.Version = conf.VersionInfo.Version
+		return compile(ctx, conf)
+	}
+}
+
+func gitVersionInfo(ctx context.Context) VersionInfo {
+	v := VersionInfo{
+		Version:   "unknown-version",
+		Dirty:     "false",
+		BuildDate: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if out, err := exec.CommandContext(ctx, "git", "describe", "--tags", "--always", "--dirty").Output(); err == nil && len(out) > 0 {
+		desc := strings.TrimLeft(strings.TrimSpace(string(out)), "v")
+		v.Version = desc
+		if strings.HasSuffix(desc, "-dirty") {
+			v.Dirty = "true"
+		}
+	}
+
+	if out, err := exec.CommandContext(ctx, "git", "rev-parse", "HEAD").Output(); err == nil {
+		v.Commit = strings.TrimSpace(string(out))
+	}
+
+	if out, err := exec.CommandContext(ctx, "git", "log", "-1", "--format=%cI").Output(); err == nil && len(out) > 0 {
+		v.BuildDate = strings.TrimSpace(string(out))
+	}
+
+	return v
+}
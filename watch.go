@@ -0,0 +1,270 @@
+package build
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// debounceWindow is how long watchSourceTree waits for fsnotify events to go
+// quiet before it cancels the running childJob and starts a new one.
+const debounceWindow = 200 * time.Millisecond
+
+// excludedDirs are directory names that are never watched, mirroring the
+// exclusions sourceTreeHash has always applied.
+var excludedDirs = map[string]bool{
+	".git":         true,
+	"..":           true,
+	"node_modules": true,
+	"build":        true,
+	"doc":          true,
+}
+
+// watchSourceTree reruns childJob every time a watched file changes. Once a
+// rebuild triggered by a change (not the initial build) completes
+// successfully, onReload runs so callers can, e.g., signal a browser
+// live-reload or bounce a sidecar service.
+func watchSourceTree(watchList WatchList, childJob job, onReload job) job {
+	return func(ctx context.Context) error {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Printf("fsnotify unavailable (%s), falling back to polling", err)
+			return pollSourceTree(ctx, watchList, childJob, onReload)
+		}
+		defer watcher.Close()
+
+		for _, p := range watchList.Paths {
+			if err := addWatches(watcher, p); err != nil {
+				log.Printf("watch %s: %s", p, err)
+			}
+		}
+
+		var mu sync.Mutex
+		var cancel context.CancelFunc
+		start := func(isReload bool) {
+			var cctx context.Context
+			cctx, cancel = context.WithCancel(ctx)
+			go func() {
+				mu.Lock()
+				defer mu.Unlock()
+				if err := childJob(cctx); err != nil {
+					log.Printf("child process: %s", err)
+					return
+				}
+				if isReload && onReload != nil {
+					if err := onReload(cctx); err != nil {
+						log.Printf("reload hook: %s", err)
+					}
+				}
+			}()
+		}
+		start(false)
+
+		var debounce *time.Timer
+		for {
+			select {
+			case <-ctx.Done():
+				cancel()
+				return ctx.Err()
+
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					cancel()
+					return nil
+				}
+				if !matchesFilter(ev.Name, watchList) {
+					continue
+				}
+				if ev.Op&fsnotify.Create != 0 {
+					base := filepath.Base(ev.Name)
+					excluded := base != "" && (base[0] == '.' || excludedDirs[base])
+					if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() && !excluded {
+						if err := addWatches(watcher, ev.Name); err != nil {
+							log.Printf("watch %s: %s", ev.Name, err)
+						}
+					}
+				}
+				if debounce == nil {
+					debounce = time.NewTimer(debounceWindow)
+				} else {
+					if !debounce.Stop() {
+						<-debounce.C
+					}
+					debounce.Reset(debounceWindow)
+				}
+
+			case <-timerC(debounce):
+				debounce = nil
+				log.Printf("Source change detected - rebuilding")
+				cancel()
+				start(true)
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					cancel()
+					return nil
+				}
+				log.Printf("watcher: %s", err)
+			}
+		}
+	}
+}
+
+// timerC returns t.C, or a nil channel (which blocks forever in a select) if
+// t is nil. This lets the debounce timer be optional in watchSourceTree's
+// select loop.
+func timerC(t *time.Timer) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
+
+// addWatches recursively registers dir and every non-excluded subdirectory
+// below it with watcher, the same set of directories sourceTreeHash used to
+// walk. If dir is a plain file, that file alone is registered.
+func addWatches(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !fi.IsDir() {
+			if p == dir {
+				return watcher.Add(p)
+			}
+			return nil
+		}
+		base := filepath.Base(p)
+		if p != dir && (base[0] == '.' || excludedDirs[base]) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(p)
+	})
+}
+
+// matchesFilter reports whether an fsnotify event path should trigger a
+// rebuild, honoring WatchList.FileFilter the same way directoryHash did.
+func matchesFilter(filePath string, w WatchList) bool {
+	if fi, err := os.Stat(filePath); err == nil && fi.IsDir() {
+		return true
+	}
+	if w.FileFilter == nil {
+		return true
+	}
+	for _, pattern := range w.FileFilter {
+		if ok, _ := filepath.Match(pattern, filePath); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(filePath)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pollSourceTree is the pre-fsnotify rehash-everything watcher, kept as a
+// fallback for platforms fsnotify doesn't support (e.g. GOOS=plan9).
+func pollSourceTree(ctx context.Context, watchList WatchList, childJob job, onReload job) error {
+	var mu sync.Mutex
+	isReload := false
+	for {
+		lastHash := sourceTreeHash(watchList)
+		current := lastHash
+		cctx, cancel := context.WithCancel(ctx)
+		reload := isReload
+		go func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if err := childJob(cctx); err != nil {
+				log.Printf("child process: %s", err)
+				return
+			}
+			if reload && onReload != nil {
+				if err := onReload(cctx); err != nil {
+					log.Printf("reload hook: %s", err)
+				}
+			}
+		}()
+
+		for lastHash == current {
+			select {
+			case <-ctx.Done():
+				cancel()
+				return ctx.Err()
+			case <-time.After(250 * time.Millisecond):
+			}
+			current = sourceTreeHash(watchList)
+		}
+
+		log.Printf("Source change detected - rebuilding")
+		cancel()
+		isReload = true
+	}
+}
+
+func sourceTreeHash(w WatchList) string {
+	h := sha1.New()
+	for _, d := range w.Paths {
+		h.Write(directoryHash(0, d, w))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func directoryHash(level int, filePath string, w WatchList) []byte {
+	h := sha1.New()
+	h.Write([]byte(filePath))
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return h.Sum(nil)
+	}
+	if fi.IsDir() {
+		base := filepath.Base(filePath)
+		if level > 0 && excludedDirs[base] {
+			return []byte{}
+		}
+		// recurse
+		var names []string
+		f, err := os.Open(filePath)
+		if err == nil {
+			names, err = f.Readdirnames(-1)
+		}
+		if err == nil {
+			for _, name := range names {
+				if name == "" || name[0] == '.' {
+					continue
+				}
+				h.Write(directoryHash(level+1, path.Join(filePath, name), w))
+			}
+		}
+	} else {
+		if w.FileFilter != nil {
+			found := false
+			for _, pattern := range w.FileFilter {
+				if ok, _ := filepath.Match(pattern, filePath); ok {
+					found = true
+				} else if ok, _ := filepath.Match(pattern, filepath.Base(filePath)); ok {
+					found = true
+				}
+			}
+			if !found {
+				return []byte{}
+			}
+		}
+		f, err := os.Open(filePath)
+		if err == nil {
+			io.Copy(h, f)
+			f.Close()
+		}
+	}
+	return h.Sum(nil)
+}